@@ -0,0 +1,75 @@
+// Package layers provides strongly-typed descriptors for the read-only and
+// scratch layers backing a WCOW or LCOW utility VM, replacing the historical
+// convention of overloading Spec.Windows.LayerFolders (a plain []string) for
+// both guest operating systems.
+package layers
+
+// DMVerityInfo describes the dm-verity root hash and related parameters
+// protecting an LCOW layer VHD, when the layer is signed/verity-protected.
+type DMVerityInfo struct {
+	RootDigest string
+	Salt       string
+	BlockSize  uint32
+}
+
+// WCOWLayer is a single read-only layer folder for a Windows utility VM. The
+// writable scratch folder is held separately, in WCOWLayers.ScratchFolder.
+type WCOWLayer struct {
+	// Folder is the path to the layer's root directory on the host.
+	Folder string
+}
+
+// LCOWLayer is a single read-only layer VHD for a Linux utility VM. A layer
+// may live on a specific GPT partition of a multi-partition VHD, which is
+// required for signed/dm-verity-protected base images.
+type LCOWLayer struct {
+	// VHDPath is the path to the layer's VHD on the host.
+	VHDPath string
+	// Partition is the 1-indexed GPT partition number within VHDPath holding
+	// the layer, or 0 if VHDPath contains a single partition.
+	Partition uint64
+	// Verity, if non-nil, describes the dm-verity protection covering this
+	// layer.
+	Verity *DMVerityInfo
+}
+
+// WCOWLayers is the full set of layers, read-only followed by the writable
+// scratch folder, backing a Windows utility VM.
+type WCOWLayers struct {
+	// Layers are the read-only layers, upper-most first, mirroring the
+	// historical LayerFolders ordering.
+	Layers []WCOWLayer
+	// ScratchFolder is the writable scratch layer folder.
+	ScratchFolder string
+}
+
+// LCOWLayers is the full set of layers backing a Linux utility VM.
+type LCOWLayers struct {
+	// Layers are the read-only layer VHDs, upper-most first.
+	Layers []LCOWLayer
+	// ScratchVHDPath is the writable scratch VHDX.
+	ScratchVHDPath string
+}
+
+// NewWCOWLayersFromFolders converts the legacy []string LayerFolders
+// convention (read-only layers, upper-most first, followed by the writable
+// scratch folder as the last entry) into a WCOWLayers. The last entry is
+// always taken as ScratchFolder; a list without a scratch entry has its last
+// read-only layer taken as ScratchFolder. It exists so
+// callers that only have Spec.Windows.LayerFolders can keep working while the
+// rest of the codebase moves to WCOWLayers.
+func NewWCOWLayersFromFolders(layerFolders []string) WCOWLayers {
+	if len(layerFolders) == 0 {
+		return WCOWLayers{}
+	}
+	scratchFolder := layerFolders[len(layerFolders)-1]
+	roFolders := layerFolders[:len(layerFolders)-1]
+	wl := WCOWLayers{
+		Layers:        make([]WCOWLayer, 0, len(roFolders)),
+		ScratchFolder: scratchFolder,
+	}
+	for _, f := range roFolders {
+		wl.Layers = append(wl.Layers, WCOWLayer{Folder: f})
+	}
+	return wl
+}
@@ -0,0 +1,25 @@
+package layers
+
+import "testing"
+
+func TestNewWCOWLayersFromFolders(t *testing.T) {
+	wl := NewWCOWLayersFromFolders([]string{`c:\layers\base`, `c:\layers\app`, `c:\containers\scratch`})
+
+	if wl.ScratchFolder != `c:\containers\scratch` {
+		t.Fatalf("ScratchFolder = %q, want %q", wl.ScratchFolder, `c:\containers\scratch`)
+	}
+	if len(wl.Layers) != 2 {
+		t.Fatalf("len(Layers) = %d, want 2", len(wl.Layers))
+	}
+	if wl.Layers[0].Folder != `c:\layers\base` || wl.Layers[1].Folder != `c:\layers\app` {
+		t.Fatalf("unexpected Layers: %+v", wl.Layers)
+	}
+}
+
+func TestNewWCOWLayersFromFoldersEmpty(t *testing.T) {
+	wl := NewWCOWLayersFromFolders(nil)
+
+	if len(wl.Layers) != 0 || wl.ScratchFolder != "" {
+		t.Fatalf("expected zero-value WCOWLayers, got %+v", wl)
+	}
+}
@@ -0,0 +1,251 @@
+// +build windows
+
+package hcsshim
+
+// Containers functions relating to an LCOW utility VM (implying v2)
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/hcsshim/schema/v2"
+	"github.com/Microsoft/hcsshim/schemaversion"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// lcowMemoryOverheadMB is the fixed kernel+initrd overhead added on top of a
+// container's requested memory when sizing an LCOW UVM. Unlike WCOW, where
+// the system partition justifies rounding up to the nearest 512MB, this
+// overhead is small and constant, so it is just added directly.
+const lcowMemoryOverheadMB = 128
+
+// Values for OptionsLCOW.PreferredRootFSType, describing how RootFSFile
+// should be interpreted when booting the LCOW UVM.
+const (
+	PreferredRootFSTypeInitRd = iota
+	PreferredRootFSTypeVHD
+)
+
+const (
+	defaultLCOWVPMemDeviceCount  = 32
+	defaultLCOWVPMemSizeBytes    = 4 * 1024 * 1024 * 1024 // 4GB
+	lcowRootfsVsmbShareName      = "rootfs"
+	lcowContainerScratchPlan9Num = 0
+)
+
+// OptionsLCOW describes how to boot and lay out storage for a Linux utility
+// VM, analogous to the WCOW-specific options baked directly into
+// createWCOWv2UVM.
+type OptionsLCOW struct {
+	KernelFile          string // Host path of the Linux kernel, used as given
+	RootFSFile          string // Host path of the initrd or VHD root filesystem, used as given
+	KernelBootOptions   string // Additional kernel command-line options
+	VPMemDeviceCount    uint32 // Number of VPMem devices available for read-only layer VHDs
+	VPMemSizeBytes      uint64 // Maximum size of each VPMem device
+	PreferredRootFSType int    // One of PreferredRootFSTypeInitRd or PreferredRootFSTypeVHD
+}
+
+// createUVM dispatches utility VM creation to the OS-specific routine,
+// mirroring the WCOW/LCOW split already used for container creation.
+func createUVM(coi *createOptionsExInternal) (Container, error) {
+	if coi.OS == "linux" {
+		return createLCOWv2UVM(coi)
+	}
+	return createWCOWv2UVM(coi)
+}
+
+// CreateUtilityVM is the exported entry point for creating a fresh (i.e.
+// not cloned from a template - see CloneContainer for that) WCOW or LCOW
+// utility VM, selected by coi.OS.
+func CreateUtilityVM(actualId string, coi *createOptionsExInternal) (Container, error) {
+	coi.actualId = actualId
+	return createUVM(coi)
+}
+
+func createLCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
+	logrus.Debugf("hcsshim::createLCOWv2UVM Creating utility VM id=%s", coi.actualId)
+
+	iocis := "invalid OCI spec:"
+	if len(coi.Spec.Hostname) > 0 {
+		return nil, fmt.Errorf("%s Hostname cannot be set for a hosting system", iocis)
+	}
+	if coi.Spec.Root != nil {
+		return nil, fmt.Errorf("%s Root must not be set for a hosting system", iocis)
+	}
+	if 0 != len(coi.Spec.Mounts) {
+		return nil, fmt.Errorf("%s Mounts must not be set for a hosting system", iocis)
+	}
+	if coi.OptionsLCOW == nil {
+		return nil, fmt.Errorf("%s OptionsLCOW must be set for an LCOW hosting system", iocis)
+	}
+
+	vpmemCount := coi.OptionsLCOW.VPMemDeviceCount
+	if vpmemCount == 0 {
+		vpmemCount = defaultLCOWVPMemDeviceCount
+	}
+	vpmemSize := coi.OptionsLCOW.VPMemSizeBytes
+	if vpmemSize == 0 {
+		vpmemSize = defaultLCOWVPMemSizeBytes
+	}
+
+	memory := int32(1024)
+	processors := int32(2)
+	if hostProcessorCount() == 1 {
+		processors = 1
+	}
+	if coi.Spec.Windows != nil && coi.Spec.Windows.Resources != nil {
+		if coi.Spec.Windows.Resources.Memory != nil && coi.Spec.Windows.Resources.Memory.Limit != nil {
+			memory = int32(*coi.Spec.Windows.Resources.Memory.Limit / 1024 / 1024) // OCI spec is in bytes. HCS takes MB
+		}
+		if coi.Spec.Windows.Resources.CPU != nil && coi.Spec.Windows.Resources.CPU.Count != nil {
+			processors = int32(*coi.Spec.Windows.Resources.CPU.Count)
+		}
+	}
+	processors = clampProcessorCount(processors, coi.AllowOvercommit)
+
+	// GrantVmAccess must run before a host path is handed to HCS as a VPMem
+	// or SCSI attachment, the same pattern createWCOWv2UVM uses for its
+	// sandbox, VSMB shares and SCSI attachments, or the restricted VM token
+	// won't be able to open the file at attach time.
+	vpmemDevices := make(map[string]hcsschemav2.VirtualMachinesResourcesVPMemDeviceV2)
+	nextVPMemIndex := 0
+
+	var initRdPath string
+	kernelBootOptions := coi.OptionsLCOW.KernelBootOptions
+	if coi.OptionsLCOW.PreferredRootFSType == PreferredRootFSTypeVHD {
+		// The root filesystem is a VHD rather than an initrd: expose it as a
+		// read-only VPMem device and tell the kernel to mount it as / instead
+		// of wiring it up as InitRdPath.
+		if err := GrantVmAccess(coi.actualId, coi.OptionsLCOW.RootFSFile); err != nil {
+			return nil, fmt.Errorf("failed to grant access to root filesystem VHD %s: %s", coi.OptionsLCOW.RootFSFile, err)
+		}
+		vpmemDevices[strconv.Itoa(nextVPMemIndex)] = hcsschemav2.VirtualMachinesResourcesVPMemDeviceV2{
+			HostPath: coi.OptionsLCOW.RootFSFile,
+			ReadOnly: true,
+		}
+		kernelBootOptions = strings.TrimSpace(fmt.Sprintf("root=/dev/pmem%d %s", nextVPMemIndex, kernelBootOptions))
+		nextVPMemIndex++
+	} else {
+		initRdPath = coi.OptionsLCOW.RootFSFile
+	}
+
+	for _, l := range coi.LCOWLayers.Layers {
+		// l.Partition and l.Verity describe how the guest mounts this VHD once
+		// it's exposed as a VPMem device; that plumbing happens over the GCS
+		// bridge, not in this HCS document.
+		if err := GrantVmAccess(coi.actualId, l.VHDPath); err != nil {
+			return nil, fmt.Errorf("failed to grant access to layer VHD %s: %s", l.VHDPath, err)
+		}
+		vpmemDevices[strconv.Itoa(nextVPMemIndex)] = hcsschemav2.VirtualMachinesResourcesVPMemDeviceV2{
+			HostPath: l.VHDPath,
+			ReadOnly: true,
+		}
+		nextVPMemIndex++
+	}
+
+	attachments := make(map[string]hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2)
+	if coi.LCOWLayers.ScratchVHDPath != "" {
+		if err := GrantVmAccess(coi.actualId, coi.LCOWLayers.ScratchVHDPath); err != nil {
+			return nil, fmt.Errorf("failed to grant access to scratch VHD %s: %s", coi.LCOWLayers.ScratchVHDPath, err)
+		}
+		attachments["0"] = hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2{
+			Path: coi.LCOWLayers.ScratchVHDPath,
+			Type: "VirtualDisk",
+		}
+	}
+
+	uvm := &hcsschemav2.ComputeSystemV2{
+		Owner:         coi.actualOwner,
+		SchemaVersion: coi.actualSchemaVersion,
+		VirtualMachine: &hcsschemav2.VirtualMachineV2{
+			Chipset: &hcsschemav2.VirtualMachinesResourcesChipsetV2{
+				LinuxKernelDirect: &hcsschemav2.VirtualMachinesResourcesLinuxKernelDirectV2{
+					KernelFilePath:    coi.OptionsLCOW.KernelFile,
+					InitRdPath:        initRdPath,
+					KernelBootOptions: kernelBootOptions,
+				},
+			},
+			ComputeTopology: &hcsschemav2.VirtualMachinesResourcesComputeTopologyV2{
+				Memory: &hcsschemav2.VirtualMachinesResourcesComputeMemoryV2{
+					Backing: "Virtual",
+					Startup: memory,
+				},
+				Processor: &hcsschemav2.VirtualMachinesResourcesComputeProcessorV2{
+					Count:    processors,
+					Topology: processorTopologyFor(processors),
+				},
+			},
+			Devices: &hcsschemav2.VirtualMachinesDevicesV2{
+				VirtualPMemControllers: []hcsschemav2.VirtualMachinesResourcesStorageVPMemControllerV2{
+					{
+						MaximumCount:     vpmemCount,
+						MaximumSizeBytes: vpmemSize,
+					},
+				},
+				VirtualPMemDevices: vpmemDevices,
+				SCSI: map[string]hcsschemav2.VirtualMachinesResourcesStorageScsiV2{
+					"0": {Attachments: attachments},
+				},
+				Plan9: []hcsschemav2.VirtualMachinesResourcesPlan9ShareV2{
+					{
+						Name: lcowRootfsVsmbShareName,
+						Path: "/",
+						Port: lcowContainerScratchPlan9Num,
+					},
+				},
+				GuestConnection: &hcsschemav2.VirtualMachinesResourcesGuestConnectionV2{
+					UseVsock:            true,
+					UseConnectedSuspend: false,
+				},
+			},
+		},
+	}
+
+	uvmb, err := json.Marshal(uvm)
+	if err != nil {
+		return nil, err
+	}
+	uvmContainer, err := createContainer(coi.actualId, string(uvmb), schemaversion.SchemaV20())
+	if err != nil {
+		logrus.Debugln("failed to create LCOW UVM: ", err)
+		return nil, err
+	}
+	uvmContainer.(*container).operatingSystem = "linux"
+	return uvmContainer, nil
+}
+
+// uvmResourcesFromLCOWSpec computes a sensible default CPU/memory allocation
+// for an LCOW utility VM from a container spec.
+func uvmResourcesFromLCOWSpec(spec *specs.Spec) (*specs.WindowsResources, error) {
+	var uvmCPUCount uint64 = 2
+	var uvmMemoryMB uint64 = 512
+	uvmResources := &specs.WindowsResources{
+		Memory: &specs.WindowsMemoryResources{},
+		CPU:    &specs.WindowsCPUResources{Count: &uvmCPUCount},
+	}
+	if hostProcessorCount() == 1 {
+		uvmCPUCount = 1
+	}
+	if spec.Windows != nil && spec.Windows.Resources != nil {
+		if spec.Windows.Resources.CPU != nil && spec.Windows.Resources.CPU.Count != nil {
+			uvmCPUCount = *spec.Windows.Resources.CPU.Count
+		}
+		if spec.Windows.Resources.Memory != nil && spec.Windows.Resources.Memory.Limit != nil {
+			uvmMemoryMB = (*spec.Windows.Resources.Memory.Limit) / 1024 / 1024
+		}
+	}
+	if uint32(uvmCPUCount) > hostProcessorCount() {
+		uvmCPUCount = uint64(hostProcessorCount())
+	}
+
+	uvmMemoryMB += lcowMemoryOverheadMB
+	uvmMemoryBytes := uvmMemoryMB * 1024 * 1024
+	uvmResources.Memory.Limit = &uvmMemoryBytes
+
+	logrus.Debugf("hcsshim: uvmResources (LCOW): Memory %d MB CPUs %d", uvmMemoryMB, *uvmResources.CPU.Count)
+
+	return uvmResources, nil
+}
@@ -0,0 +1,41 @@
+// +build windows
+
+package networking
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Microsoft/hcsshim/hns"
+)
+
+// HNSConfigurator resolves a network namespace by listing the HNS endpoints
+// CNI has already attached to it, entirely in-process. It is the default
+// Configurator and requires no external service.
+type HNSConfigurator struct{}
+
+func (HNSConfigurator) ConfigureNetworking(ctx context.Context, namespaceID string) (*NetworkingConfig, error) {
+	endpointIDs, err := hns.GetNamespaceEndpoints(namespaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoints for namespace %s: %s", namespaceID, err)
+	}
+
+	cfg := &NetworkingConfig{NICs: make([]NIC, 0, len(endpointIDs))}
+	for _, endpointID := range endpointIDs {
+		endpoint, err := hns.GetHNSEndpointByID(endpointID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get HNS endpoint %s: %s", endpointID, err)
+		}
+		cfg.NICs = append(cfg.NICs, NIC{
+			AdapterID:        endpoint.Id,
+			EndpointID:       endpoint.Id,
+			MacAddress:       endpoint.MacAddress,
+			IPv4Address:      endpoint.IPAddress.String(),
+			IPv4Gateway:      endpoint.GatewayAddress,
+			IPv4PrefixLength: endpoint.PrefixLength,
+			DNSServers:       endpoint.DNSServerList,
+			DNSSuffix:        endpoint.DNSSuffix,
+		})
+	}
+	return cfg, nil
+}
@@ -0,0 +1,46 @@
+// Package networking provides a pluggable way to resolve a network
+// namespace into the NIC configuration a utility VM needs, mirroring the
+// ncproxy model: a small client interface that either resolves endpoints
+// in-process via HNS, or delegates to an external proxy over ttrpc.
+package networking
+
+import "context"
+
+// Backend selects which Configurator createWCOWv2UVM uses to resolve a
+// network namespace into NICs.
+type Backend int
+
+const (
+	// BackendHNS resolves endpoints in-process using local HNS calls.
+	BackendHNS Backend = iota
+	// BackendTTRPC delegates resolution to an external ncproxy-style service
+	// over a ttrpc connection.
+	BackendTTRPC
+)
+
+// NIC describes a single network adapter to attach to a utility VM.
+type NIC struct {
+	AdapterID        string // GUID to assign the virtual network adapter
+	EndpointID       string // HNS endpoint ID the adapter is bound to
+	MacAddress       string
+	IPv4Address      string
+	IPv4Gateway      string
+	IPv4PrefixLength uint8
+	IPv6Address      string
+	IPv6Gateway      string
+	IPv6PrefixLength uint8
+	DNSServers       []string
+	DNSSuffix        string
+}
+
+// NetworkingConfig is the set of NICs to attach to a utility VM for a given
+// network namespace.
+type NetworkingConfig struct {
+	NICs []NIC
+}
+
+// Configurator resolves a network namespace into the set of NICs that
+// should be attached to the utility VM hosting it.
+type Configurator interface {
+	ConfigureNetworking(ctx context.Context, namespaceID string) (*NetworkingConfig, error)
+}
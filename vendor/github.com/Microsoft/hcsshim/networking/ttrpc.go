@@ -0,0 +1,62 @@
+// +build windows
+
+package networking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/containerd/ttrpc"
+)
+
+// TTRPCConfigurator delegates namespace resolution to an external
+// ncproxy-style service reachable over a ttrpc connection, rather than
+// resolving HNS endpoints in-process.
+type TTRPCConfigurator struct {
+	client *ttrpc.Client
+}
+
+// NewTTRPCConfigurator dials addr and returns a Configurator backed by the
+// resulting ttrpc connection. addr is a named pipe path (e.g.
+// `\\.\pipe\ncproxy`), the transport ncproxy actually uses on Windows, or a
+// unix socket path.
+func NewTTRPCConfigurator(addr string) (*TTRPCConfigurator, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial network proxy %s: %s", addr, err)
+	}
+	return &TTRPCConfigurator{client: ttrpc.NewClient(conn)}, nil
+}
+
+func dial(addr string) (net.Conn, error) {
+	if strings.HasPrefix(addr, `\\.\pipe\`) {
+		return winio.DialPipe(addr, nil)
+	}
+	return net.DialTimeout("unix", addr, 5*time.Second)
+}
+
+func (c *TTRPCConfigurator) ConfigureNetworking(ctx context.Context, namespaceID string) (*NetworkingConfig, error) {
+	req := &configureNetworkingRequest{NamespaceID: namespaceID}
+	resp := &configureNetworkingResponse{}
+	if err := c.client.Call(ctx, "ncproxy.NetworkConfigProxy", "ConfigureNetworking", req, resp); err != nil {
+		return nil, fmt.Errorf("ConfigureNetworking RPC for namespace %s failed: %s", namespaceID, err)
+	}
+	return &NetworkingConfig{NICs: resp.NICs}, nil
+}
+
+// Close shuts down the underlying ttrpc connection.
+func (c *TTRPCConfigurator) Close() error {
+	return c.client.Close()
+}
+
+type configureNetworkingRequest struct {
+	NamespaceID string
+}
+
+type configureNetworkingResponse struct {
+	NICs []NIC
+}
@@ -0,0 +1,93 @@
+// +build windows
+
+package hcsshim
+
+// Utility VM networking: resolving a network namespace into NIC
+// configuration at create time, and hot-adding/removing NICs afterwards so
+// CNI plugins can attach endpoints once the UVM is already running.
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Microsoft/hcsshim/networking"
+	"github.com/Microsoft/hcsshim/schema/v2"
+)
+
+// resolveNetworking picks the Configurator selected by coi.NetworkingBackend
+// and resolves coi.Spec.Windows.Network.NetworkNamespace into NIC
+// configuration for the UVM being created.
+func resolveNetworking(coi *createOptionsExInternal) (*networking.NetworkingConfig, error) {
+	var configurator networking.Configurator
+	switch coi.NetworkingBackend {
+	case networking.BackendTTRPC:
+		c, err := networking.NewTTRPCConfigurator(coi.NetworkProxyAddress)
+		if err != nil {
+			return nil, err
+		}
+		configurator = c
+	default:
+		configurator = networking.HNSConfigurator{}
+	}
+	return configurator.ConfigureNetworking(context.Background(), coi.Spec.Windows.Network.NetworkNamespace)
+}
+
+// networkAdapterFromNIC converts a resolved networking.NIC into the
+// corresponding HCS schema document.
+func networkAdapterFromNIC(nic networking.NIC) hcsschemav2.VirtualMachinesResourcesNetworkAdapterV2 {
+	return hcsschemav2.VirtualMachinesResourcesNetworkAdapterV2{
+		EndpointId:         nic.EndpointID,
+		MacAddress:         nic.MacAddress,
+		IPAddress:          nic.IPv4Address,
+		PrefixLength:       nic.IPv4PrefixLength,
+		GatewayAddress:     nic.IPv4Gateway,
+		IPv6Address:        nic.IPv6Address,
+		IPv6PrefixLength:   nic.IPv6PrefixLength,
+		IPv6GatewayAddress: nic.IPv6Gateway,
+		DNSServerList:      strings.Join(nic.DNSServers, " "),
+		DNSSuffix:          nic.DNSSuffix,
+	}
+}
+
+// networkAdapterKey returns the key a NIC should be stored under in a
+// ComputeSystemV2's NetworkAdapters map: its AdapterID, when the
+// Configurator assigned one, or the NIC's position otherwise. HotAddNIC and
+// HotRemoveNIC do not use this key; they identify an adapter by endpoint ID.
+func networkAdapterKey(i int, nic networking.NIC) string {
+	if nic.AdapterID != "" {
+		return nic.AdapterID
+	}
+	return strconv.Itoa(i)
+}
+
+// HotAddNIC attaches a network endpoint to an already-running utility VM,
+// for use by CNI plugins that resolve endpoints after the UVM has booted
+// rather than up front via NetworkingBackend.
+func HotAddNIC(uvm Container, endpointID string) error {
+	c, ok := uvm.(*container)
+	if !ok {
+		return fmt.Errorf("HotAddNIC: container is not a v2 utility VM")
+	}
+	nic := networking.NIC{EndpointID: endpointID}
+	return c.Modify(&hcsschemav2.ModifySettingsRequestV2{
+		ResourceType: "NetworkAdapter",
+		RequestType:  "Add",
+		Settings:     networkAdapterFromNIC(nic),
+	})
+}
+
+// HotRemoveNIC detaches a previously hot-added network endpoint from a
+// running utility VM.
+func HotRemoveNIC(uvm Container, endpointID string) error {
+	c, ok := uvm.(*container)
+	if !ok {
+		return fmt.Errorf("HotRemoveNIC: container is not a v2 utility VM")
+	}
+	return c.Modify(&hcsschemav2.ModifySettingsRequestV2{
+		ResourceType: "NetworkAdapter",
+		RequestType:  "Remove",
+		Settings:     hcsschemav2.VirtualMachinesResourcesNetworkAdapterV2{EndpointId: endpointID},
+	})
+}
@@ -0,0 +1,110 @@
+package processorinfo
+
+import "unsafe"
+
+// relationship values passed to GetLogicalProcessorInformationEx.
+const (
+	relationProcessorGroup = 4
+	relationNumaNode       = 1
+	relationAll            = 0xffff
+)
+
+// ProcessorGroupInfo describes one Windows processor group and how many
+// logical processors it contains.
+type ProcessorGroupInfo struct {
+	GroupNumber           uint16
+	LogicalProcessorCount uint32
+}
+
+// NUMANodeInfo describes one NUMA node: which processor group its logical
+// processors live in, and a bitmask of which processors in that group belong
+// to the node.
+type NUMANodeInfo struct {
+	NodeNumber           uint32
+	GroupNumber          uint16
+	LogicalProcessorMask uint64
+}
+
+// HostProcessorInfo is the true host-wide processor topology, spanning all
+// processor groups, as opposed to runtime.NumCPU which is scoped to the
+// current process.
+type HostProcessorInfo struct {
+	LogicalProcessorCount uint32
+	ProcessorGroups       []ProcessorGroupInfo
+	NUMANodes             []NUMANodeInfo
+}
+
+// systemLogicalProcessorInformationEx mirrors the fixed-size header of
+// SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX; the relationship-specific payload
+// follows it in the same buffer entry.
+type systemLogicalProcessorInformationEx struct {
+	Relationship uint32
+	Size         uint32
+}
+
+// Layout constants for the relationship-specific payloads that follow a
+// systemLogicalProcessorInformationEx header, taken directly from the
+// GROUP_RELATIONSHIP, PROCESSOR_GROUP_INFO, NUMA_NODE_RELATIONSHIP and
+// GROUP_AFFINITY struct definitions in winnt.h. These are fixed, documented
+// ABI layouts, not something to infer from field order.
+const (
+	// GROUP_RELATIONSHIP: WORD MaximumGroupCount; WORD ActiveGroupCount;
+	// BYTE Reserved[20]; PROCESSOR_GROUP_INFO GroupInfo[ANYSIZE_ARRAY];
+	groupRelationshipActiveGroupCountOffset = 2
+	groupRelationshipGroupInfoOffset        = 24
+
+	// PROCESSOR_GROUP_INFO: BYTE MaximumProcessorCount; BYTE
+	// ActiveProcessorCount; BYTE Reserved[38]; KAFFINITY ActiveProcessorMask;
+	processorGroupInfoActiveProcessorCountOffset = 1
+	processorGroupInfoSize                       = 48
+
+	// NUMA_NODE_RELATIONSHIP: DWORD NodeNumber; BYTE Reserved[18]; WORD
+	// GroupCount; GROUP_AFFINITY GroupMask;
+	numaNodeRelationshipGroupMaskOffset = 24
+
+	// GROUP_AFFINITY: KAFFINITY Mask; WORD Group; WORD Reserved[3];
+	groupAffinityGroupOffset = 8
+)
+
+// parseSystemLogicalProcessorInformationEx walks a buffer filled in by
+// GetLogicalProcessorInformationEx(RelationAll, ...) and extracts the
+// processor-group and NUMA-node relationships. It touches no Windows APIs,
+// so it can be exercised with a synthetic buffer on any platform.
+func parseSystemLogicalProcessorInformationEx(buf []byte) *HostProcessorInfo {
+	info := &HostProcessorInfo{}
+	length := uint32(len(buf))
+	offset := uint32(0)
+	for offset < length {
+		entry := (*systemLogicalProcessorInformationEx)(unsafe.Pointer(&buf[offset]))
+		payload := buf[offset+8 : offset+entry.Size]
+
+		switch entry.Relationship {
+		case relationProcessorGroup:
+			activeGroupCount := *(*uint16)(unsafe.Pointer(&payload[groupRelationshipActiveGroupCountOffset]))
+			base := groupRelationshipGroupInfoOffset
+			for i := 0; i < int(activeGroupCount); i++ {
+				groupInfo := payload[base : base+processorGroupInfoSize]
+				activeCount := uint32(groupInfo[processorGroupInfoActiveProcessorCountOffset])
+				info.ProcessorGroups = append(info.ProcessorGroups, ProcessorGroupInfo{
+					GroupNumber:           uint16(i),
+					LogicalProcessorCount: activeCount,
+				})
+				info.LogicalProcessorCount += activeCount
+				base += processorGroupInfoSize
+			}
+		case relationNumaNode:
+			nodeNumber := *(*uint32)(unsafe.Pointer(&payload[0]))
+			mask := *(*uint64)(unsafe.Pointer(&payload[numaNodeRelationshipGroupMaskOffset]))
+			groupNumber := *(*uint16)(unsafe.Pointer(&payload[numaNodeRelationshipGroupMaskOffset+groupAffinityGroupOffset]))
+			info.NUMANodes = append(info.NUMANodes, NUMANodeInfo{
+				NodeNumber:           nodeNumber,
+				GroupNumber:          groupNumber,
+				LogicalProcessorMask: mask,
+			})
+		}
+
+		offset += entry.Size
+	}
+
+	return info
+}
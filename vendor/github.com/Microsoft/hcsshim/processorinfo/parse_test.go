@@ -0,0 +1,77 @@
+package processorinfo
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// buildGroupRelationshipEntry builds one SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX
+// entry of type RelationGroup, containing the given per-group active
+// processor counts and masks.
+func buildGroupRelationshipEntry(activeCounts []byte, masks []uint64) []byte {
+	groupInfoSize := processorGroupInfoSize
+	payloadLen := groupRelationshipGroupInfoOffset + groupInfoSize*len(activeCounts)
+	entry := make([]byte, 8+payloadLen)
+
+	binary.LittleEndian.PutUint32(entry[0:4], relationProcessorGroup)
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(len(entry)))
+
+	payload := entry[8:]
+	binary.LittleEndian.PutUint16(payload[groupRelationshipActiveGroupCountOffset:], uint16(len(activeCounts)))
+
+	base := groupRelationshipGroupInfoOffset
+	for i, count := range activeCounts {
+		groupInfo := payload[base : base+groupInfoSize]
+		groupInfo[processorGroupInfoActiveProcessorCountOffset] = count
+		binary.LittleEndian.PutUint64(groupInfo[40:48], masks[i])
+		base += groupInfoSize
+	}
+
+	return entry
+}
+
+// buildNumaNodeRelationshipEntry builds one SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX
+// entry of type RelationNumaNode.
+func buildNumaNodeRelationshipEntry(nodeNumber uint32, groupNumber uint16, mask uint64) []byte {
+	payloadLen := numaNodeRelationshipGroupMaskOffset + groupAffinityGroupOffset + 8 // Mask + Group + Reserved[3]
+	entry := make([]byte, 8+payloadLen)
+
+	binary.LittleEndian.PutUint32(entry[0:4], relationNumaNode)
+	binary.LittleEndian.PutUint32(entry[4:8], uint32(len(entry)))
+
+	payload := entry[8:]
+	binary.LittleEndian.PutUint32(payload[0:4], nodeNumber)
+	binary.LittleEndian.PutUint64(payload[numaNodeRelationshipGroupMaskOffset:], mask)
+	binary.LittleEndian.PutUint16(payload[numaNodeRelationshipGroupMaskOffset+groupAffinityGroupOffset:], groupNumber)
+
+	return entry
+}
+
+func TestParseSystemLogicalProcessorInformationEx(t *testing.T) {
+	var buf []byte
+	buf = append(buf, buildGroupRelationshipEntry([]byte{64, 32}, []uint64{0xffffffffffffffff, 0x00000000ffffffff})...)
+	buf = append(buf, buildNumaNodeRelationshipEntry(0, 0, 0xffffffffffffffff)...)
+	buf = append(buf, buildNumaNodeRelationshipEntry(1, 1, 0x00000000ffffffff)...)
+
+	info := parseSystemLogicalProcessorInformationEx(buf)
+
+	if info.LogicalProcessorCount != 96 {
+		t.Fatalf("LogicalProcessorCount = %d, want 96", info.LogicalProcessorCount)
+	}
+	if len(info.ProcessorGroups) != 2 {
+		t.Fatalf("len(ProcessorGroups) = %d, want 2", len(info.ProcessorGroups))
+	}
+	if info.ProcessorGroups[0].LogicalProcessorCount != 64 || info.ProcessorGroups[1].LogicalProcessorCount != 32 {
+		t.Fatalf("unexpected ProcessorGroups: %+v", info.ProcessorGroups)
+	}
+
+	if len(info.NUMANodes) != 2 {
+		t.Fatalf("len(NUMANodes) = %d, want 2", len(info.NUMANodes))
+	}
+	if info.NUMANodes[0].NodeNumber != 0 || info.NUMANodes[0].GroupNumber != 0 || info.NUMANodes[0].LogicalProcessorMask != 0xffffffffffffffff {
+		t.Fatalf("unexpected NUMANodes[0]: %+v", info.NUMANodes[0])
+	}
+	if info.NUMANodes[1].NodeNumber != 1 || info.NUMANodes[1].GroupNumber != 1 || info.NUMANodes[1].LogicalProcessorMask != 0x00000000ffffffff {
+		t.Fatalf("unexpected NUMANodes[1]: %+v", info.NUMANodes[1])
+	}
+}
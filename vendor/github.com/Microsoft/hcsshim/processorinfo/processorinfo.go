@@ -0,0 +1,48 @@
+// +build windows
+
+// Package processorinfo enumerates the host's true logical processor and
+// NUMA topology via GetLogicalProcessorInformationEx, rather than relying on
+// runtime.NumCPU (which only sees the calling process's processor group and
+// so undercounts on hosts with 65+ logical processors).
+package processorinfo
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32                          = windows.NewLazySystemDLL("kernel32.dll")
+	procGetLogicalProcessorInformationEx = modkernel32.NewProc("GetLogicalProcessorInformationEx")
+)
+
+// Get queries the host for its true logical processor count and its
+// processor-group/NUMA-node layout.
+func Get() (*HostProcessorInfo, error) {
+	var length uint32
+	r, _, err := procGetLogicalProcessorInformationEx.Call(
+		uintptr(relationAll),
+		0,
+		uintptr(unsafe.Pointer(&length)),
+	)
+	if r != 0 || length == 0 {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx: unexpected success sizing buffer: %s", err)
+	}
+	if err != windows.ERROR_INSUFFICIENT_BUFFER {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx: failed to size buffer: %s", err)
+	}
+
+	buf := make([]byte, length)
+	r, _, err = procGetLogicalProcessorInformationEx.Call(
+		uintptr(relationAll),
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&length)),
+	)
+	if r == 0 {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx: %s", err)
+	}
+
+	return parseSystemLogicalProcessorInformationEx(buf[:length]), nil
+}
@@ -0,0 +1,74 @@
+// +build windows
+
+package hcsshim
+
+import (
+	"github.com/Microsoft/hcsshim/processorinfo"
+	"github.com/Microsoft/hcsshim/schema/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// hostProcessorCount returns the true host-wide logical processor count,
+// spanning every processor group, falling back to numCPU() (accurate only
+// for single-group, <=64 LP hosts) if the query fails.
+func hostProcessorCount() uint32 {
+	info, err := processorinfo.Get()
+	if err != nil {
+		logrus.Debugf("hcsshim: falling back to numCPU(): failed to query host processor info: %s", err)
+		return uint32(numCPU())
+	}
+	return info.LogicalProcessorCount
+}
+
+// clampProcessorCount clamps requested to the host's real logical processor
+// count unless allowOvercommit is set.
+func clampProcessorCount(requested int32, allowOvercommit bool) int32 {
+	hostCount := int32(hostProcessorCount())
+	if !allowOvercommit && requested > hostCount {
+		logrus.Debugf("hcsshim: clamping requested vCPU count %d to host logical processor count %d", requested, hostCount)
+		return hostCount
+	}
+	return requested
+}
+
+// processorTopologyFor builds a per-NUMA-node vCPU allocation when the host
+// has multiple NUMA nodes and the requested vCPU count exceeds a single
+// node's logical processor count. It returns nil when a flat allocation is
+// sufficient, which is the common case.
+func processorTopologyFor(processors int32) *hcsschemav2.VirtualMachinesResourcesProcessorTopologyV2 {
+	info, err := processorinfo.Get()
+	if err != nil || len(info.NUMANodes) < 2 {
+		return nil
+	}
+
+	if processors <= int32(popcount(info.NUMANodes[0].LogicalProcessorMask)) {
+		return nil
+	}
+
+	topology := &hcsschemav2.VirtualMachinesResourcesProcessorTopologyV2{}
+	remaining := processors
+	for _, node := range info.NUMANodes {
+		if remaining <= 0 {
+			break
+		}
+		nodeCount := int32(popcount(node.LogicalProcessorMask))
+		if nodeCount > remaining {
+			nodeCount = remaining
+		}
+		topology.NUMANodes = append(topology.NUMANodes, hcsschemav2.VirtualMachinesResourcesNumaProcessorsV2{
+			VirtualNodeNumber:     node.NodeNumber,
+			VirtualProcessorCount: nodeCount,
+		})
+		remaining -= nodeCount
+	}
+	return topology
+}
+
+func popcount(mask uint64) int {
+	count := 0
+	for mask != 0 {
+		count += int(mask & 1)
+		mask >>= 1
+	}
+	return count
+}
@@ -0,0 +1,19 @@
+package resources
+
+import (
+	"fmt"
+	"strings"
+)
+
+// multiError combines several release failures into a single error so that
+// Release can report everything that went wrong instead of just the first
+// failure.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, 0, len(m))
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d resource(s) failed to release: %s", len(m), strings.Join(msgs, "; "))
+}
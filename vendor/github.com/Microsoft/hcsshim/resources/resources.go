@@ -0,0 +1,47 @@
+// Package resources provides a small, composable way to track host-side
+// resources allocated while building up a container or utility VM (sandbox
+// files, VSMB share registrations, SCSI attachments, ...) so that they can
+// be unwound deterministically, whether that is because creation failed
+// part-way through or because the owning container was terminated.
+package resources
+
+import "context"
+
+// ResourceCloser is a single host-side resource that must be released when
+// its owner goes away. Resources.Release calls each closer it holds at most
+// once, since it drops them all after the first Release; a closer released
+// by other means as well must tolerate being released more than once.
+type ResourceCloser interface {
+	Release(ctx context.Context) error
+}
+
+// Resources is an ordered aggregate of ResourceClosers. Add as resources are
+// acquired; Release tears them down in LIFO order, the same order a set of
+// deferred cleanups would run in, so that a later resource (for example a
+// SCSI attachment into a VSMB share) is released before the thing it
+// depended on.
+type Resources struct {
+	closers []ResourceCloser
+}
+
+// Add appends a resource to be released, in the order it was acquired.
+func (r *Resources) Add(c ResourceCloser) {
+	r.closers = append(r.closers, c)
+}
+
+// Release releases all resources in LIFO order, collecting every error
+// rather than stopping at the first one so that a failure releasing one
+// resource doesn't leak the rest.
+func (r *Resources) Release(ctx context.Context) error {
+	var errs []error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if err := r.closers[i].Release(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.closers = nil
+	if len(errs) > 0 {
+		return multiError(errs)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeCloser struct {
+	err      error
+	released *[]int
+	id       int
+}
+
+func (f *fakeCloser) Release(ctx context.Context) error {
+	*f.released = append(*f.released, f.id)
+	return f.err
+}
+
+func TestResourcesReleaseLIFOOrder(t *testing.T) {
+	var released []int
+	r := &Resources{}
+	r.Add(&fakeCloser{id: 1, released: &released})
+	r.Add(&fakeCloser{id: 2, released: &released})
+	r.Add(&fakeCloser{id: 3, released: &released})
+
+	if err := r.Release(context.Background()); err != nil {
+		t.Fatalf("Release() = %v, want nil", err)
+	}
+
+	want := []int{3, 2, 1}
+	if len(released) != len(want) {
+		t.Fatalf("released = %v, want %v", released, want)
+	}
+	for i := range want {
+		if released[i] != want[i] {
+			t.Fatalf("released = %v, want %v", released, want)
+		}
+	}
+}
+
+func TestResourcesReleaseCollectsAllErrors(t *testing.T) {
+	var released []int
+	errA := errors.New("a failed")
+	errB := errors.New("b failed")
+	r := &Resources{}
+	r.Add(&fakeCloser{id: 1, released: &released, err: errA})
+	r.Add(&fakeCloser{id: 2, released: &released})
+	r.Add(&fakeCloser{id: 3, released: &released, err: errB})
+
+	err := r.Release(context.Background())
+	if err == nil {
+		t.Fatal("Release() = nil, want an error summarizing both failures")
+	}
+	if len(released) != 3 {
+		t.Fatalf("released = %v, want all 3 resources attempted despite errors", released)
+	}
+	msg := err.Error()
+	for _, want := range []string{errA.Error(), errB.Error()} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error message %q does not mention %q", msg, want)
+		}
+	}
+}
+
+func TestResourcesReleaseResetsClosers(t *testing.T) {
+	var released []int
+	r := &Resources{}
+	r.Add(&fakeCloser{id: 1, released: &released})
+
+	if err := r.Release(context.Background()); err != nil {
+		t.Fatalf("Release() = %v, want nil", err)
+	}
+	if err := r.Release(context.Background()); err != nil {
+		t.Fatalf("second Release() = %v, want nil (nothing left to release)", err)
+	}
+	if len(released) != 1 {
+		t.Fatalf("released = %v, want a single entry from the first Release", released)
+	}
+}
@@ -0,0 +1,43 @@
+// +build windows
+
+package hcsschemav2
+
+// VirtualMachinesResourcesLinuxKernelDirectV2 configures a Linux utility VM to
+// direct-boot a kernel and initrd (or VHD root filesystem) rather than going
+// through UEFI firmware the way a WCOW UVM does.
+type VirtualMachinesResourcesLinuxKernelDirectV2 struct {
+	KernelFilePath    string `json:"KernelFilePath,omitempty"`
+	InitRdPath        string `json:"InitRdPath,omitempty"`
+	KernelBootOptions string `json:"KernelBootOptions,omitempty"`
+}
+
+// VirtualMachinesResourcesStorageVPMemControllerV2 describes the virtual
+// persistent memory controller used to expose read-only LCOW layer VHDs to
+// the guest without going through the SCSI/9p path.
+type VirtualMachinesResourcesStorageVPMemControllerV2 struct {
+	MaximumCount     uint32 `json:"MaximumCount,omitempty"`
+	MaximumSizeBytes uint64 `json:"MaximumSizeBytes,omitempty"`
+}
+
+// VirtualMachinesResourcesVPMemDeviceV2 maps a single host VHD onto one of
+// the UVM's VPMem devices.
+type VirtualMachinesResourcesVPMemDeviceV2 struct {
+	HostPath string `json:"HostPath,omitempty"`
+	ReadOnly bool   `json:"ReadOnly,omitempty"`
+}
+
+// VirtualMachinesResourcesPlan9ShareV2 describes a Plan9 (9p) share used to
+// expose the LCOW container root to the guest.
+type VirtualMachinesResourcesPlan9ShareV2 struct {
+	Name string `json:"Name,omitempty"`
+	Path string `json:"Path,omitempty"`
+	Port uint32 `json:"Port,omitempty"`
+}
+
+// VirtualMachinesResourcesGuestConnectionV2 configures the guest connection
+// used to bridge the GCS protocol into the UVM. LCOW UVMs use an HvSocket
+// vsock transport rather than the WCOW GuestInterface bridge.
+type VirtualMachinesResourcesGuestConnectionV2 struct {
+	UseVsock            bool `json:"UseVsock,omitempty"`
+	UseConnectedSuspend bool `json:"UseConnectedSuspend,omitempty"`
+}
@@ -0,0 +1,17 @@
+package hcsschemav2
+
+// VirtualMachinesResourcesNetworkAdapterV2 describes a single synthetic
+// network adapter attached to a utility VM.
+type VirtualMachinesResourcesNetworkAdapterV2 struct {
+	EndpointId         string `json:"EndpointId,omitempty"`
+	MacAddress         string `json:"MacAddress,omitempty"`
+	IPAddress          string `json:"IPAddress,omitempty"`
+	PrefixLength       uint8  `json:"PrefixLength,omitempty"`
+	GatewayAddress     string `json:"GatewayAddress,omitempty"`
+	IPv6Address        string `json:"IPv6Address,omitempty"`
+	IPv6PrefixLength   uint8  `json:"IPv6PrefixLength,omitempty"`
+	IPv6GatewayAddress string `json:"IPv6GatewayAddress,omitempty"`
+	DNSServerList      string `json:"DNSServerList,omitempty"`
+	DNSSuffix          string `json:"DNSSuffix,omitempty"`
+	EnableLowMetric    bool   `json:"EnableLowMetric,omitempty"`
+}
@@ -0,0 +1,16 @@
+package hcsschemav2
+
+// VirtualMachinesResourcesNumaProcessorsV2 assigns a number of vCPUs to a
+// single virtual NUMA node.
+type VirtualMachinesResourcesNumaProcessorsV2 struct {
+	VirtualNodeNumber     uint32 `json:"VirtualNodeNumber,omitempty"`
+	VirtualProcessorCount int32  `json:"VirtualProcessorCount,omitempty"`
+}
+
+// VirtualMachinesResourcesProcessorTopologyV2 describes how a UVM's vCPUs
+// are distributed across virtual NUMA nodes, used when the host has
+// multiple NUMA nodes and a flat processor count isn't enough to express
+// the desired placement.
+type VirtualMachinesResourcesProcessorTopologyV2 struct {
+	NUMANodes []VirtualMachinesResourcesNumaProcessorsV2 `json:"NumaNodes,omitempty"`
+}
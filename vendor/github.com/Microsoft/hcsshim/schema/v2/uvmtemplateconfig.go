@@ -0,0 +1,56 @@
+// +build windows
+
+package hcsschemav2
+
+// VsmbFlagNoDirectMap marks a VSMB share as safe to include in a saved UVM
+// template: the host is not permitted to direct-map the backing file, so the
+// share can be torn down and later re-registered against a different VM ID
+// without corrupting guest state that was saved while the share was mapped.
+// It is combined with VsmbFlagPseudoOplocks | VsmbFlagTakeBackupPrivilege |
+// VsmbFlagShareRead when creating shares for a UVM that will be templated.
+const VsmbFlagNoDirectMap = 1 << 9
+
+// VirtualMachinesResourcesRestoreStateV2 directs the virtual machine to boot
+// from a previously saved-state/memory pair rather than performing a fresh
+// UEFI boot. It is the Chipset equivalent of VirtualMachinesResourcesUefiV2
+// used when cloning a UVM from a template.
+type VirtualMachinesResourcesRestoreStateV2 struct {
+	SavedStateFilePath    string `json:"SavedStateFilePath,omitempty"`
+	MemoryBackingFilePath string `json:"MemoryBackingFilePath,omitempty"`
+}
+
+// UVMTemplateConfig describes a UVM that was saved as a template by
+// SaveAsTemplate. It records everything a later clone needs in order to
+// restore the saved state and re-register the same VSMB shares and SCSI
+// attachments against a new VM ID, rather than creating them from scratch.
+type UVMTemplateConfig struct {
+	// SourceVMID is the GUID of the utility VM the template was saved from.
+	SourceVMID string
+	// SavedStateFilePath is the saved-state (.vmrs) file produced when the
+	// source VM was frozen and saved.
+	SavedStateFilePath string
+	// MemoryBackingFilePath is the memory-backing file produced alongside
+	// SavedStateFilePath.
+	MemoryBackingFilePath string
+	// VSMBShares are the VSMB shares that were attached to the source VM.
+	VSMBShares []UVMTemplateVSMBShare
+	// SCSIAttachments are the SCSI attachments that were attached to the
+	// source VM.
+	SCSIAttachments []UVMTemplateSCSIAttachment
+}
+
+// UVMTemplateVSMBShare records enough about a VSMB share to re-register it
+// against a cloned UVM's VM ID.
+type UVMTemplateVSMBShare struct {
+	Name  string
+	Path  string
+	Flags uint32
+}
+
+// UVMTemplateSCSIAttachment records enough about a SCSI attachment to
+// re-register it against a cloned UVM's VM ID.
+type UVMTemplateSCSIAttachment struct {
+	Controller int
+	LUN        int
+	Path       string
+}
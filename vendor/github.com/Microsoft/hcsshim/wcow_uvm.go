@@ -5,11 +5,15 @@ package hcsshim
 // Containers functions relating to a WCOW utility VM (implying v2)
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"github.com/Microsoft/hcsshim/layers"
+	"github.com/Microsoft/hcsshim/resources"
 	"github.com/Microsoft/hcsshim/schema/v2"
 	"github.com/Microsoft/hcsshim/schemaversion"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -17,20 +21,59 @@ import (
 )
 
 // CreateWCOWUVMSandbox is a helper to create a sandbox for a Windows utility VM
-// with permissions to the specified VM ID in a specified directory
-func CreateWCOWUVMSandbox(imagePath, destDirectory, vmID string) error {
-	sourceSandbox := filepath.Join(imagePath, `UtilityVM\SystemTemplate.vhdx`)
+// with permissions to the specified VM ID in a specified directory. When
+// skipCopy is set, destDirectory is assumed to already contain sandbox.vhdx
+// (for example a disk restored from a saved template) and only the
+// GrantVmAccess step is performed. The returned ResourceCloser revokes the
+// VM's access to the sandbox and deletes the file; the caller is responsible
+// for releasing it once the sandbox is no longer needed, or on any failure
+// path after this call succeeds.
+func CreateWCOWUVMSandbox(imagePath, destDirectory, vmID string, skipCopy bool) (resources.ResourceCloser, error) {
 	targetSandbox := filepath.Join(destDirectory, "sandbox.vhdx")
-	if err := CopyFile(sourceSandbox, targetSandbox, true); err != nil {
-		return err
+	if !skipCopy {
+		sourceSandbox := filepath.Join(imagePath, `UtilityVM\SystemTemplate.vhdx`)
+		if err := CopyFile(sourceSandbox, targetSandbox, true); err != nil {
+			return nil, err
+		}
 	}
 	if err := GrantVmAccess(vmID, targetSandbox); err != nil {
-		// TODO: Delete the file?
-		return err
+		if !skipCopy {
+			os.Remove(targetSandbox)
+		}
+		return nil, err
+	}
+	return &sandboxCloser{vmID: vmID, path: targetSandbox}, nil
+}
+
+// sandboxCloser releases a sandbox.vhdx created by CreateWCOWUVMSandbox,
+// revoking the VM's access to it before deleting the file.
+type sandboxCloser struct {
+	vmID string
+	path string
+}
+
+func (c *sandboxCloser) Release(ctx context.Context) error {
+	if err := RevokeVmAccess(c.vmID, c.path); err != nil {
+		logrus.Debugf("hcsshim: failed to revoke access to %s for %s: %s", c.path, c.vmID, err)
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete UVM sandbox %s: %s", c.path, err)
 	}
 	return nil
 }
 
+// vmAccessCloser revokes a VM's access to a path that createWCOWv2UVM
+// registered but does not own the lifetime of (a VSMB share or SCSI
+// attachment backed by a layer or template file).
+type vmAccessCloser struct {
+	vmID string
+	path string
+}
+
+func (c *vmAccessCloser) Release(ctx context.Context) error {
+	return RevokeVmAccess(c.vmID, c.path)
+}
+
 // UVMResourcesFromContainerSpec takes a container spec and generates a
 // resources structure suitable for creating a utility VM to host the container.
 // This is really only relevant for a client that is running a single container
@@ -39,11 +82,14 @@ func CreateWCOWUVMSandbox(imagePath, destDirectory, vmID string) error {
 func UVMResourcesFromContainerSpec(spec *specs.Spec) (*specs.WindowsResources, error) {
 	// TODO: Processors. File bug. V2 schema for VM doesn't allow weight/limit, just on compute system.
 
-	if spec == nil && spec.Linux != nil { // TODO
-		return nil, fmt.Errorf("UVMResourcesFromContainerSpec not supported for LCOW yet")
+	if spec == nil {
+		return nil, fmt.Errorf("invalid spec")
+	}
+	if spec.Linux != nil {
+		return uvmResourcesFromLCOWSpec(spec)
 	}
 
-	if spec == nil || spec.Windows == nil {
+	if spec.Windows == nil {
 		return nil, fmt.Errorf("invalid spec")
 	}
 	var uvmCPUCount uint64 = 2
@@ -52,7 +98,7 @@ func UVMResourcesFromContainerSpec(spec *specs.Spec) (*specs.WindowsResources, e
 		Memory: &specs.WindowsMemoryResources{},
 		CPU:    &specs.WindowsCPUResources{Count: &uvmCPUCount},
 	}
-	if numCPU() == 1 {
+	if hostProcessorCount() == 1 {
 		uvmCPUCount = 1
 	}
 	if spec.Windows.Resources != nil {
@@ -63,6 +109,9 @@ func UVMResourcesFromContainerSpec(spec *specs.Spec) (*specs.WindowsResources, e
 			uvmMemoryMB = (*spec.Windows.Resources.Memory.Limit) / 1024 / 1024
 		}
 	}
+	if uint32(uvmCPUCount) > hostProcessorCount() {
+		uvmCPUCount = uint64(hostProcessorCount())
+	}
 
 	// Add 256MB and round up to nearest 512MB
 	uvmMemoryMB += 256
@@ -82,13 +131,30 @@ func UVMResourcesFromContainerSpec(spec *specs.Spec) (*specs.WindowsResources, e
 // Read-only-layers followed by an optional read-write layer. The RO layers are in reverse
 // order so that the upper-most RO layer is at the start, and the base OS layer is the
 // end.
+//
+// Deprecated: use LocateWCOWUVMFolderFromLayers, which takes a strongly-typed
+// []layers.WCOWLayer instead of overloading a plain []string. This is kept as
+// a thin shim for callers that still only have a LayerFolders []string.
 func LocateWCOWUVMFolderFromLayerFolders(layerFolders []string) (string, error) {
+	// Every entry is searched, as the read-write layer at the end is optional.
+	wcowLayers := make([]layers.WCOWLayer, 0, len(layerFolders))
+	for _, f := range layerFolders {
+		wcowLayers = append(wcowLayers, layers.WCOWLayer{Folder: f})
+	}
+	return LocateWCOWUVMFolderFromLayers(wcowLayers)
+}
+
+// LocateWCOWUVMFolderFromLayers searches a set of WCOW layers to determine
+// the "uppermost" layer which has a utility VM image. The order of the
+// layers is (for historical reasons) upper-most RO layer first, base OS
+// layer last.
+func LocateWCOWUVMFolderFromLayers(wcowLayers []layers.WCOWLayer) (string, error) {
 	var uvmFolder string
 	index := 0
-	for _, layerFolder := range layerFolders {
-		_, err := os.Stat(filepath.Join(layerFolder, `UtilityVM`))
+	for _, layer := range wcowLayers {
+		_, err := os.Stat(filepath.Join(layer.Folder, `UtilityVM`))
 		if err == nil {
-			uvmFolder = layerFolder
+			uvmFolder = layer.Folder
 			break
 		}
 		if !os.IsNotExist(err) {
@@ -99,16 +165,28 @@ func LocateWCOWUVMFolderFromLayerFolders(layerFolders []string) (string, error)
 	if uvmFolder == "" {
 		return "", fmt.Errorf("utility VM folder could not be found in layers")
 	}
-	logrus.Debugf("hcsshim::LocateWCOWUVMFolderFromLayerFolders Index %d of %d possibles (%s)", index, len(layerFolders), uvmFolder)
+	logrus.Debugf("hcsshim::LocateWCOWUVMFolderFromLayers Index %d of %d possibles (%s)", index, len(wcowLayers), uvmFolder)
 	return uvmFolder, nil
 }
 
+// effectiveWCOWLayers returns coi.WCOWLayers, the strongly-typed replacement
+// for Spec.Windows.LayerFolders, falling back to converting LayerFolders for
+// callers that haven't moved over yet.
+func effectiveWCOWLayers(coi *createOptionsExInternal) layers.WCOWLayers {
+	if len(coi.WCOWLayers.Layers) == 0 && coi.WCOWLayers.ScratchFolder == "" {
+		return layers.NewWCOWLayersFromFolders(coi.Spec.Windows.LayerFolders)
+	}
+	return coi.WCOWLayers
+}
+
 func createWCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
 	logrus.Debugf("hcsshim::createWCOWv2UVM Creating utility VM id=%s", coi.actualId)
 
+	wcowLayers := effectiveWCOWLayers(coi)
+
 	iocis := "invalid OCI spec:"
-	if len(coi.Spec.Windows.LayerFolders) < 2 {
-		return nil, fmt.Errorf("%s Windows.LayerFolders must have length of at least 2 for a hosting system", iocis)
+	if len(wcowLayers.Layers) == 0 || wcowLayers.ScratchFolder == "" {
+		return nil, fmt.Errorf("%s WCOWLayers (or, for legacy callers, Windows.LayerFolders) must have at least one read-only layer and a scratch folder for a hosting system", iocis)
 	}
 	if len(coi.Spec.Hostname) > 0 {
 		return nil, fmt.Errorf("%s Hostname cannot be set for a hosting system", iocis)
@@ -128,20 +206,15 @@ func createWCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
 	if coi.Spec.Windows.CredentialSpec != nil {
 		return nil, fmt.Errorf("%s Windows.CredentialSpec must not be set for a hosting system", iocis)
 	}
-	if coi.Spec.Windows.Network != nil {
-		return nil, fmt.Errorf("%s Windows.Network must not be set for a hosting system", iocis) // Need to revisit, but blocking everything currently not hooked up
-	}
 	if 0 != len(coi.Spec.Mounts) {
 		return nil, fmt.Errorf("%s Mounts must not be set for a hosting system", iocis)
 	}
-
-	uvmFolder, err := LocateWCOWUVMFolderFromLayerFolders(coi.Spec.Windows.LayerFolders)
-	if err != nil {
-		return nil, fmt.Errorf("failed to locate utility VM folder from layer folders: %s", err)
+	if coi.IsClone && coi.TemplateConfig == nil {
+		return nil, fmt.Errorf("%s TemplateConfig must be set when IsClone is set", iocis)
 	}
 
-	// Create the sandbox in the top-most layer folder, creating the folder if it doesn't already exist.
-	sandboxFolder := coi.Spec.Windows.LayerFolders[len(coi.Spec.Windows.LayerFolders)-1]
+	// Create the sandbox in the scratch folder, creating it if it doesn't already exist.
+	sandboxFolder := wcowLayers.ScratchFolder
 	logrus.Debugf("hcsshim::createWCOWv2UVM Sandbox folder: %s", sandboxFolder)
 
 	// Create the directory if it doesn't exist
@@ -152,21 +225,116 @@ func createWCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
 		}
 	}
 
-	// Create sandbox.vhdx in the sandbox folder based on the template, granting the correct permissions to it
-	if err := CreateWCOWUVMSandbox(uvmFolder, sandboxFolder, coi.actualId); err != nil {
-		return nil, fmt.Errorf("failed to create UVM sandbox: %s", err)
+	attachments := make(map[string]hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2)
+	var vsmbShares []hcsschemav2.VirtualMachinesResourcesStorageVSmbShareV2
+	var chipset *hcsschemav2.VirtualMachinesResourcesChipsetV2
+
+	// res accumulates every host-side resource acquired below (the sandbox,
+	// VSMB share grants, SCSI attachment grants) so that a failure anywhere
+	// in this function unwinds them in LIFO order instead of leaking them.
+	// On success it is stored on the returned container (see below).
+	res := &resources.Resources{}
+	fail := func(err error) (Container, error) {
+		if relErr := res.Release(context.Background()); relErr != nil {
+			logrus.Debugf("hcsshim::createWCOWv2UVM failed to release resources after error %q: %s", err, relErr)
+		}
+		return nil, err
 	}
 
-	attachments := make(map[string]hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2)
-	attachments["0"] = hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2{
-		Path: filepath.Join(sandboxFolder, "sandbox.vhdx"),
-		Type: "VirtualDisk",
+	if coi.IsClone {
+		// Cloning from a saved template: skip creating a fresh sandbox.vhdx and
+		// re-register the template's VSMB shares and SCSI attachments against
+		// this VM's ID instead.
+		for i, a := range coi.TemplateConfig.SCSIAttachments {
+			if err := GrantVmAccess(coi.actualId, a.Path); err != nil {
+				return fail(fmt.Errorf("failed to grant clone access to %s: %s", a.Path, err))
+			}
+			res.Add(&vmAccessCloser{vmID: coi.actualId, path: a.Path})
+			attachments[strconv.Itoa(i)] = hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2{
+				Path: a.Path,
+				Type: "VirtualDisk",
+			}
+		}
+		for _, s := range coi.TemplateConfig.VSMBShares {
+			if err := GrantVmAccess(coi.actualId, s.Path); err != nil {
+				return fail(fmt.Errorf("failed to grant clone access to VSMB share %s: %s", s.Path, err))
+			}
+			res.Add(&vmAccessCloser{vmID: coi.actualId, path: s.Path})
+			vsmbShares = append(vsmbShares, hcsschemav2.VirtualMachinesResourcesStorageVSmbShareV2{
+				Flags: hcsschemav2.VsmbFlag(s.Flags),
+				Name:  s.Name,
+				Path:  s.Path,
+			})
+		}
+		chipset = &hcsschemav2.VirtualMachinesResourcesChipsetV2{
+			RestoreState: &hcsschemav2.VirtualMachinesResourcesRestoreStateV2{
+				SavedStateFilePath:    coi.TemplateConfig.SavedStateFilePath,
+				MemoryBackingFilePath: coi.TemplateConfig.MemoryBackingFilePath,
+			},
+		}
+	} else {
+		uvmFolder, err := LocateWCOWUVMFolderFromLayers(wcowLayers.Layers)
+		if err != nil {
+			return fail(fmt.Errorf("failed to locate utility VM folder from layers: %s", err))
+		}
+
+		// Create sandbox.vhdx in the sandbox folder based on the template, granting the correct permissions to it
+		sandboxCloser, err := CreateWCOWUVMSandbox(uvmFolder, sandboxFolder, coi.actualId, false)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create UVM sandbox: %s", err))
+		}
+		res.Add(sandboxCloser)
+		attachments["0"] = hcsschemav2.VirtualMachinesResourcesStorageAttachmentV2{
+			Path: filepath.Join(sandboxFolder, "sandbox.vhdx"),
+			Type: "VirtualDisk",
+		}
+
+		vsmbFlags := hcsschemav2.VsmbFlagReadOnly | hcsschemav2.VsmbFlagPseudoOplocks | hcsschemav2.VsmbFlagTakeBackupPrivilege | hcsschemav2.VsmbFlagCacheIO | hcsschemav2.VsmbFlagShareRead
+		if coi.IsTemplate {
+			// The template's backing files must not be direct-mapped so that
+			// freezing and saving the UVM later in SaveAsTemplate leaves them in a clean,
+			// re-mountable state for later clones.
+			vsmbFlags |= hcsschemav2.VsmbFlagNoDirectMap
+		}
+		vsmbPath := filepath.Join(uvmFolder, `UtilityVM\Files`)
+		if err := GrantVmAccess(coi.actualId, vsmbPath); err != nil {
+			return fail(fmt.Errorf("failed to grant access to VSMB share %s: %s", vsmbPath, err))
+		}
+		res.Add(&vmAccessCloser{vmID: coi.actualId, path: vsmbPath})
+		vsmbShares = append(vsmbShares, hcsschemav2.VirtualMachinesResourcesStorageVSmbShareV2{
+			Flags: vsmbFlags,
+			Name:  "os",
+			Path:  vsmbPath,
+		})
+
+		chipset = &hcsschemav2.VirtualMachinesResourcesChipsetV2{
+			UEFI: &hcsschemav2.VirtualMachinesResourcesUefiV2{
+				BootThis: &hcsschemav2.VirtualMachinesResourcesUefiBootEntryV2{
+					DevicePath: `\EFI\Microsoft\Boot\bootmgfw.efi`,
+					DiskNumber: 0,
+					UefiDevice: "VMBFS",
+				},
+			},
+		}
 	}
+
+	var networkAdapters map[string]hcsschemav2.VirtualMachinesResourcesNetworkAdapterV2
+	if coi.Spec.Windows.Network != nil {
+		netCfg, err := resolveNetworking(coi)
+		if err != nil {
+			return fail(fmt.Errorf("failed to configure networking: %s", err))
+		}
+		networkAdapters = make(map[string]hcsschemav2.VirtualMachinesResourcesNetworkAdapterV2)
+		for i, nic := range netCfg.NICs {
+			networkAdapters[networkAdapterKey(i, nic)] = networkAdapterFromNIC(nic)
+		}
+	}
+
 	scsi := make(map[string]hcsschemav2.VirtualMachinesResourcesStorageScsiV2)
 	scsi["0"] = hcsschemav2.VirtualMachinesResourcesStorageScsiV2{Attachments: attachments}
 	memory := int32(1024)
 	processors := int32(2)
-	if numCPU() == 1 {
+	if hostProcessorCount() == 1 {
 		processors = 1
 	}
 	if coi.Spec.Windows.Resources != nil {
@@ -177,19 +345,12 @@ func createWCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
 			processors = int32(*coi.Spec.Windows.Resources.CPU.Count)
 		}
 	}
+	processors = clampProcessorCount(processors, coi.AllowOvercommit)
 	uvm := &hcsschemav2.ComputeSystemV2{
 		Owner:         coi.actualOwner,
 		SchemaVersion: coi.actualSchemaVersion,
 		VirtualMachine: &hcsschemav2.VirtualMachineV2{
-			Chipset: &hcsschemav2.VirtualMachinesResourcesChipsetV2{
-				UEFI: &hcsschemav2.VirtualMachinesResourcesUefiV2{
-					BootThis: &hcsschemav2.VirtualMachinesResourcesUefiBootEntryV2{
-						DevicePath: `\EFI\Microsoft\Boot\bootmgfw.efi`,
-						DiskNumber: 0,
-						UefiDevice: "VMBFS",
-					},
-				},
-			},
+			Chipset: chipset,
 			ComputeTopology: &hcsschemav2.VirtualMachinesResourcesComputeTopologyV2{
 				Memory: &hcsschemav2.VirtualMachinesResourcesComputeMemoryV2{
 					Backing:             "Virtual",
@@ -197,33 +358,92 @@ func createWCOWv2UVM(coi *createOptionsExInternal) (Container, error) {
 					DirectFileMappingMB: 1024, // Sensible default, but could be a tuning parameter somewhere
 				},
 				Processor: &hcsschemav2.VirtualMachinesResourcesComputeProcessorV2{
-					Count: processors,
+					Count:    processors,
+					Topology: processorTopologyFor(processors),
 				},
 			},
 
 			Devices: &hcsschemav2.VirtualMachinesDevicesV2{
-				// Add networking here.... TODO
-				SCSI: scsi,
-				VirtualSMBShares: []hcsschemav2.VirtualMachinesResourcesStorageVSmbShareV2{hcsschemav2.VirtualMachinesResourcesStorageVSmbShareV2{
-					Flags: hcsschemav2.VsmbFlagReadOnly | hcsschemav2.VsmbFlagPseudoOplocks | hcsschemav2.VsmbFlagTakeBackupPrivilege | hcsschemav2.VsmbFlagCacheIO | hcsschemav2.VsmbFlagShareRead,
-					Name:  "os",
-					Path:  filepath.Join(uvmFolder, `UtilityVM\Files`),
-				}},
-				GuestInterface: &hcsschemav2.VirtualMachinesResourcesGuestInterfaceV2{ConnectToBridge: true},
+				SCSI:             scsi,
+				VirtualSMBShares: vsmbShares,
+				NetworkAdapters:  networkAdapters,
+				GuestInterface:   &hcsschemav2.VirtualMachinesResourcesGuestInterfaceV2{ConnectToBridge: true},
 			},
 		},
 	}
 
 	uvmb, err := json.Marshal(uvm)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 	uvmContainer, err := createContainer(coi.actualId, string(uvmb), schemaversion.SchemaV20())
 	if err != nil {
 		logrus.Debugln("failed to create UVM: ", err)
-		return nil, err
+		return fail(err)
 	}
 	uvmContainer.(*container).scsiLocations.hostPath[0][0] = attachments["0"].Path
 	uvmContainer.(*container).operatingSystem = "windows"
+	// res is handed off to the container here. Nothing in this file releases
+	// it on the success path; that is left to container.Terminate, which is
+	// defined in container.go, outside this vendor snapshot.
+	uvmContainer.(*container).resources = res
 	return uvmContainer, nil
 }
+
+// SaveAsTemplate freezes a utility VM created with IsTemplate set on its
+// createOptionsExInternal and persists its saved-state to savedStatePath and
+// its memory-backing file to memoryBackingPath. The returned
+// UVMTemplateConfig records the VSMB shares and SCSI attachments that were
+// registered against coi so that a later CloneContainer call can re-register
+// them against a new VM ID instead of recreating them from scratch.
+func SaveAsTemplate(uvm Container, coi *createOptionsExInternal, savedStatePath, memoryBackingPath string) (*hcsschemav2.UVMTemplateConfig, error) {
+	c, ok := uvm.(*container)
+	if !ok {
+		return nil, fmt.Errorf("SaveAsTemplate: container is not a v2 utility VM")
+	}
+	if err := c.Pause(); err != nil {
+		return nil, fmt.Errorf("failed to freeze UVM %s for templating: %s", coi.actualId, err)
+	}
+	if err := c.Save(hcsschemav2.VirtualMachinesResourcesRestoreStateV2{SavedStateFilePath: savedStatePath, MemoryBackingFilePath: memoryBackingPath}); err != nil {
+		return nil, fmt.Errorf("failed to save UVM template %s: %s", coi.actualId, err)
+	}
+
+	wcowLayers := effectiveWCOWLayers(coi)
+	uvmFolder, err := LocateWCOWUVMFolderFromLayers(wcowLayers.Layers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate utility VM folder from layers: %s", err)
+	}
+	sandboxFolder := wcowLayers.ScratchFolder
+
+	return &hcsschemav2.UVMTemplateConfig{
+		SourceVMID:            coi.actualId,
+		SavedStateFilePath:    savedStatePath,
+		MemoryBackingFilePath: memoryBackingPath,
+		VSMBShares: []hcsschemav2.UVMTemplateVSMBShare{
+			{
+				Name:  "os",
+				Path:  filepath.Join(uvmFolder, `UtilityVM\Files`),
+				Flags: uint32(hcsschemav2.VsmbFlagReadOnly | hcsschemav2.VsmbFlagPseudoOplocks | hcsschemav2.VsmbFlagTakeBackupPrivilege | hcsschemav2.VsmbFlagCacheIO | hcsschemav2.VsmbFlagShareRead | hcsschemav2.VsmbFlagNoDirectMap),
+			},
+		},
+		SCSIAttachments: []hcsschemav2.UVMTemplateSCSIAttachment{
+			{
+				Controller: 0,
+				LUN:        0,
+				Path:       filepath.Join(sandboxFolder, "sandbox.vhdx"),
+			},
+		},
+	}, nil
+}
+
+// CloneContainer creates a new WCOW utility VM by restoring it from a saved
+// UVMTemplateConfig produced by SaveAsTemplate instead of booting fresh. It
+// mirrors createContainer but routes through createWCOWv2UVM with IsClone
+// set so the template's VSMB shares and SCSI attachments are re-registered
+// against the new VM ID rather than recreated.
+func CloneContainer(actualId string, coi *createOptionsExInternal, template *hcsschemav2.UVMTemplateConfig) (Container, error) {
+	coi.actualId = actualId
+	coi.IsClone = true
+	coi.TemplateConfig = template
+	return createWCOWv2UVM(coi)
+}